@@ -0,0 +1,146 @@
+// MIT License
+//
+// Copyright (c) 2024 sphinx-core
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package hashtree
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// Conf configures a BadgerStore.
+type Conf struct {
+	// DBPath is the directory Badger stores its LSM tree and value log in.
+	DBPath string
+	// GCInterval is how often the value log garbage collector runs. A
+	// zero value disables background GC.
+	GCInterval time.Duration
+}
+
+// BadgerStore is a LeafStore backed by BadgerDB, for workloads where the
+// LSM write/GC profile suits the leaf/signature volume better than
+// goleveldb.
+type BadgerStore struct {
+	db     *badger.DB
+	stopGC chan struct{}
+}
+
+// NewBadgerStore opens (creating if necessary) a Badger database per conf
+// and, if conf.GCInterval is non-zero, starts a background goroutine that
+// periodically reclaims value log space.
+func NewBadgerStore(conf Conf) (*BadgerStore, error) {
+	opts := badger.DefaultOptions(conf.DBPath)
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, fmt.Errorf("opening badger store at %q: %w", conf.DBPath, err)
+	}
+
+	s := &BadgerStore{db: db, stopGC: make(chan struct{})}
+	if conf.GCInterval > 0 {
+		go s.runValueLogGC(conf.GCInterval)
+	}
+	return s, nil
+}
+
+// runValueLogGC reclaims value log space on a fixed interval until the
+// store is closed. Badger's RunValueLogGC rewrites at most one file per
+// call, so each tick keeps reclaiming until there is nothing left to do.
+func (s *BadgerStore) runValueLogGC(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for s.db.RunValueLogGC(0.5) == nil {
+			}
+		case <-s.stopGC:
+			return
+		}
+	}
+}
+
+func (s *BadgerStore) Put(key, value []byte) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(key, value)
+	})
+}
+
+func (s *BadgerStore) Get(key []byte) ([]byte, error) {
+	var value []byte
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(key)
+		if err != nil {
+			return err
+		}
+		value, err = item.ValueCopy(nil)
+		return err
+	})
+	if err == badger.ErrKeyNotFound {
+		return nil, ErrNotFound
+	}
+	return value, err
+}
+
+func (s *BadgerStore) Delete(key []byte) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(key)
+	})
+}
+
+func (s *BadgerStore) BatchPut(pairs map[string][]byte) error {
+	wb := s.db.NewWriteBatch()
+	defer wb.Cancel()
+	for key, value := range pairs {
+		if err := wb.Set([]byte(key), value); err != nil {
+			return err
+		}
+	}
+	return wb.Flush()
+}
+
+func (s *BadgerStore) Iterate(prefix []byte, fn func(key, value []byte) error) error {
+	return s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = prefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			key := append([]byte(nil), item.Key()...)
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if err := fn(key, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BadgerStore) Close() error {
+	close(s.stopGC)
+	return s.db.Close()
+}
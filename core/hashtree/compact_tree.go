@@ -0,0 +1,449 @@
+// MIT License
+//
+// Copyright (c) 2024 sphinx-core
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package hashtree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrInvalidProofIndex is returned when a proof is requested for a leaf
+// index or tree size that is out of range.
+var ErrInvalidProofIndex = errors.New("hashtree: invalid leaf index or tree size")
+
+// HashLeaf returns the domain-separated hash of a single leaf, using the
+// same version-tagged scheme as BuildHashTree. It is exported for callers,
+// such as hashtree/monitor, that need to recompute tree hashes
+// independently of a CompactTree's persisted state.
+func HashLeaf(leaf []byte) []byte {
+	return computeHash(leaf)
+}
+
+// HashNode returns the domain-separated hash of two sibling hashes, using
+// the same version-tagged scheme as BuildHashTree.
+func HashNode(left, right []byte) []byte {
+	return computeNodeHash(left, right)
+}
+
+// CompactTree is an append-only Merkle tree in the CT-log "compact range"
+// representation: instead of keeping every node of the tree in memory, it
+// keeps one hash per set bit of the current size, each representing the
+// root of a perfect subtree. Appending a leaf only ever touches O(log n)
+// of these hashes, so the tree can grow to billions of leaves without
+// holding the whole structure in memory.
+//
+// Leaves and the compact frontier are persisted to the leaf store so a
+// process restart can resume appending without rehashing every past leaf.
+type CompactTree struct {
+	mu    sync.Mutex
+	store LeafStore
+	size  uint64
+	// nodes[level] holds the root hash of the perfect subtree at that
+	// level that is part of the current frontier; it is only meaningful
+	// when bit `level` of size is set.
+	nodes [][]byte
+}
+
+// NewCompactTree creates an empty CompactTree backed by store.
+func NewCompactTree(store LeafStore) *CompactTree {
+	return &CompactTree{store: store}
+}
+
+// LoadCompactTree restores a CompactTree's frontier from the leaf store,
+// allowing a restarted process to resume appending without rescanning past
+// leaves. If no persisted state exists, an empty tree is returned.
+func LoadCompactTree(store LeafStore) (*CompactTree, error) {
+	t := NewCompactTree(store)
+
+	sizeBytes, err := store.Get([]byte("ct:size"))
+	if err == ErrNotFound {
+		return t, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("loading compact tree size: %w", err)
+	}
+	t.size = binary.BigEndian.Uint64(sizeBytes)
+
+	levels := bitsLen64(t.size)
+	t.nodes = make([][]byte, levels)
+	for level := 0; level < levels; level++ {
+		if (t.size>>uint(level))&1 == 0 {
+			continue
+		}
+		hash, err := store.Get(ctNodeKey(level))
+		if err != nil {
+			return nil, fmt.Errorf("loading compact tree frontier at level %d: %w", level, err)
+		}
+		t.nodes[level] = hash
+	}
+	return t, nil
+}
+
+// Append adds a leaf to the tree, persisting both the leaf and the updated
+// compact frontier, and returns the new tree size and root hash. The
+// in-memory frontier is only published once the write durably succeeds,
+// so a failed BatchPut never leaves the live tree ahead of what a
+// restart would recover via LoadCompactTree.
+func (t *CompactTree) Append(leaf []byte) (uint64, []byte, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	pairs := map[string][]byte{
+		string(leafKey(t.size)): leaf,
+	}
+
+	hash := computeHash(leaf)
+	size := t.size
+	for level := 0; (size>>uint(level))&1 == 1; level++ {
+		hash = computeNodeHash(t.nodes[level], hash)
+	}
+	level := trailingOnes(size)
+
+	newNodes := append([][]byte(nil), t.nodes...)
+	if level == len(newNodes) {
+		newNodes = append(newNodes, hash)
+	} else {
+		newNodes[level] = hash
+	}
+	pairs[string(ctNodeKey(level))] = hash
+
+	newSize := t.size + 1
+	sizeBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(sizeBytes, newSize)
+	pairs["ct:size"] = sizeBytes
+
+	if err := t.store.BatchPut(pairs); err != nil {
+		return 0, nil, fmt.Errorf("persisting compact tree append: %w", err)
+	}
+
+	t.nodes = newNodes
+	t.size = newSize
+
+	return t.size, t.rootLocked(), nil
+}
+
+// Size returns the current number of leaves in the tree.
+func (t *CompactTree) Size() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.size
+}
+
+// Root returns the current root hash, or nil if the tree is empty.
+func (t *CompactTree) Root() []byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.rootLocked()
+}
+
+// rootLocked combines the frontier hashes into the tree root. Callers
+// must hold t.mu.
+func (t *CompactTree) rootLocked() []byte {
+	return CombineFrontier(t.size, t.nodes)
+}
+
+// CombineFrontier computes the Merkle root implied by a compact frontier:
+// one hash per set bit of size, nodes[level] holding the root of the
+// perfect subtree at that level. The smallest present subtree is always
+// the innermost term — e.g. for a frontier with set bits at levels
+// 0 < 1 < 2, the root is H(nodes[2], H(nodes[1], nodes[0])), not the
+// reverse — since that is the order a CT-style append builds the tree in
+// as new, smaller subtrees get folded into the accumulated hash on the
+// right.
+//
+// It is exported so independent consumers of the frontier representation
+// (hashtree/monitor keeps its own copy rather than trusting a signer's
+// persisted one) compute the identical root instead of each re-deriving
+// the combination order.
+func CombineFrontier(size uint64, nodes [][]byte) []byte {
+	if size == 0 {
+		return nil
+	}
+	var acc []byte
+	for level := 0; level < len(nodes); level++ {
+		if (size>>uint(level))&1 == 0 {
+			continue
+		}
+		if acc == nil {
+			acc = nodes[level]
+		} else {
+			acc = computeNodeHash(nodes[level], acc)
+		}
+	}
+	return acc
+}
+
+// leafAt fetches the raw leaf data at index from the leaf store.
+func (t *CompactTree) leafAt(index uint64) ([]byte, error) {
+	return t.store.Get(leafKey(index))
+}
+
+// mth computes the Merkle Tree Hash (RFC 6962 MTH) of the size leaves
+// starting at index start, recursing down to single leaves. It is used to
+// build inclusion and consistency proofs against leaf ranges that are not
+// necessarily aligned to the compact frontier.
+func (t *CompactTree) mth(start, size uint64) ([]byte, error) {
+	if size == 1 {
+		leaf, err := t.leafAt(start)
+		if err != nil {
+			return nil, err
+		}
+		return computeHash(leaf), nil
+	}
+	k := largestPowerOfTwoLessThan(size)
+	left, err := t.mth(start, k)
+	if err != nil {
+		return nil, err
+	}
+	right, err := t.mth(start+k, size-k)
+	if err != nil {
+		return nil, err
+	}
+	return computeNodeHash(left, right), nil
+}
+
+// InclusionProof returns the audit path proving that the leaf at leafIndex
+// is included in the tree of the given treeSize, following the RFC 6962
+// PATH algorithm.
+func (t *CompactTree) InclusionProof(leafIndex, treeSize uint64) ([][]byte, error) {
+	if treeSize == 0 || leafIndex >= treeSize {
+		return nil, ErrInvalidProofIndex
+	}
+	return t.path(leafIndex, 0, treeSize)
+}
+
+// path implements RFC 6962's PATH(m, D[n]) over the leaf range
+// [start, start+size).
+func (t *CompactTree) path(m, start, size uint64) ([][]byte, error) {
+	if size == 1 {
+		return nil, nil
+	}
+	k := largestPowerOfTwoLessThan(size)
+	if m < k {
+		proof, err := t.path(m, start, k)
+		if err != nil {
+			return nil, err
+		}
+		sibling, err := t.mth(start+k, size-k)
+		if err != nil {
+			return nil, err
+		}
+		return append(proof, sibling), nil
+	}
+	proof, err := t.path(m-k, start+k, size-k)
+	if err != nil {
+		return nil, err
+	}
+	sibling, err := t.mth(start, k)
+	if err != nil {
+		return nil, err
+	}
+	return append(proof, sibling), nil
+}
+
+// ConsistencyProof returns the proof that the tree of size newSize is an
+// append-only extension of the tree of size oldSize, following the
+// RFC 6962 PROOF/SUBPROOF algorithm.
+func (t *CompactTree) ConsistencyProof(oldSize, newSize uint64) ([][]byte, error) {
+	if oldSize == 0 || oldSize > newSize || newSize > t.size {
+		return nil, ErrInvalidProofIndex
+	}
+	if oldSize == newSize {
+		return nil, nil
+	}
+	return t.subProof(oldSize, 0, newSize, true)
+}
+
+// subProof implements RFC 6962's SUBPROOF(m, D[n], b) over the leaf range
+// [start, start+size).
+func (t *CompactTree) subProof(m, start, size uint64, b bool) ([][]byte, error) {
+	if m == size {
+		if b {
+			return nil, nil
+		}
+		hash, err := t.mth(start, size)
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{hash}, nil
+	}
+	k := largestPowerOfTwoLessThan(size)
+	if m <= k {
+		proof, err := t.subProof(m, start, k, b)
+		if err != nil {
+			return nil, err
+		}
+		sibling, err := t.mth(start+k, size-k)
+		if err != nil {
+			return nil, err
+		}
+		return append(proof, sibling), nil
+	}
+	proof, err := t.subProof(m-k, start+k, size-k, false)
+	if err != nil {
+		return nil, err
+	}
+	sibling, err := t.mth(start, k)
+	if err != nil {
+		return nil, err
+	}
+	return append(proof, sibling), nil
+}
+
+// VerifyInclusion checks that proof demonstrates leaf's inclusion at index
+// in the tree of the given size with the given root.
+func VerifyInclusion(root, leaf []byte, proof [][]byte, index, size uint64) bool {
+	if size == 0 || index >= size {
+		return false
+	}
+	computed, err := inclusionRoot(computeHash(leaf), index, size, proof)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(computed, root)
+}
+
+// inclusionRoot rebuilds the root hash from a leaf hash and its audit
+// path, mirroring the recursion used to generate the proof.
+func inclusionRoot(leafHash []byte, m, size uint64, proof [][]byte) ([]byte, error) {
+	if size == 1 {
+		if len(proof) != 0 {
+			return nil, ErrInvalidProofIndex
+		}
+		return leafHash, nil
+	}
+	if len(proof) == 0 {
+		return nil, ErrInvalidProofIndex
+	}
+	k := largestPowerOfTwoLessThan(size)
+	sibling := proof[len(proof)-1]
+	rest := proof[:len(proof)-1]
+	if m < k {
+		left, err := inclusionRoot(leafHash, m, k, rest)
+		if err != nil {
+			return nil, err
+		}
+		return computeNodeHash(left, sibling), nil
+	}
+	right, err := inclusionRoot(leafHash, m-k, size-k, rest)
+	if err != nil {
+		return nil, err
+	}
+	return computeNodeHash(sibling, right), nil
+}
+
+// VerifyConsistency checks that proof demonstrates the tree of oldSize
+// rooted at oldRoot is a prefix of the tree of newSize rooted at newRoot.
+// It follows RFC 6962 section 2.1.2's iterative verification algorithm
+// rather than replaying SUBPROOF's recursion in reverse: the two are not
+// mirror images of each other once oldSize isn't a power of two, since
+// SUBPROOF's "b" flag changes which side of a split keeps anchoring the
+// old root.
+func VerifyConsistency(oldRoot, newRoot []byte, proof [][]byte, oldSize, newSize uint64) bool {
+	if oldSize == 0 || oldSize > newSize {
+		return false
+	}
+	if oldSize == newSize {
+		return len(proof) == 0 && bytes.Equal(oldRoot, newRoot)
+	}
+	if len(proof) == 0 {
+		return false
+	}
+
+	// If oldSize is itself a power of two, SUBPROOF never emits oldRoot
+	// as a proof element (it's implicit), so the verifier must prepend it.
+	path := proof
+	if isPowerOfTwo(oldSize) {
+		path = append([][]byte{oldRoot}, proof...)
+	}
+
+	fn, sn := oldSize-1, newSize-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+
+	fr, sr := path[0], path[0]
+	for _, c := range path[1:] {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = computeNodeHash(c, fr)
+			sr = computeNodeHash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = computeNodeHash(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+
+	return bytes.Equal(fr, oldRoot) && bytes.Equal(sr, newRoot)
+}
+
+func leafKey(index uint64) []byte {
+	return []byte(fmt.Sprintf("leaf-%d", index))
+}
+
+func ctNodeKey(level int) []byte {
+	return []byte(fmt.Sprintf("ct:node:%d", level))
+}
+
+func bitsLen64(x uint64) int {
+	n := 0
+	for x > 0 {
+		n++
+		x >>= 1
+	}
+	return n
+}
+
+func trailingOnes(x uint64) int {
+	n := 0
+	for x&1 == 1 {
+		n++
+		x >>= 1
+	}
+	return n
+}
+
+func isPowerOfTwo(x uint64) bool {
+	return x > 0 && x&(x-1) == 0
+}
+
+// largestPowerOfTwoLessThan returns the largest power of two strictly less
+// than n, for n > 1.
+func largestPowerOfTwoLessThan(n uint64) uint64 {
+	k := uint64(1)
+	for k*2 < n {
+		k *= 2
+	}
+	return k
+}
@@ -0,0 +1,194 @@
+// MIT License
+//
+// Copyright (c) 2024 sphinx-core
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package hashtree
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// memStore is a minimal in-memory LeafStore for tests that don't need a
+// real backend.
+type memStore struct {
+	data map[string][]byte
+}
+
+func newMemStore() *memStore {
+	return &memStore{data: make(map[string][]byte)}
+}
+
+func (s *memStore) Put(key, value []byte) error {
+	s.data[string(key)] = append([]byte(nil), value...)
+	return nil
+}
+
+func (s *memStore) Get(key []byte) ([]byte, error) {
+	v, ok := s.data[string(key)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return v, nil
+}
+
+func (s *memStore) Delete(key []byte) error {
+	delete(s.data, string(key))
+	return nil
+}
+
+func (s *memStore) BatchPut(pairs map[string][]byte) error {
+	for k, v := range pairs {
+		s.data[k] = append([]byte(nil), v...)
+	}
+	return nil
+}
+
+func (s *memStore) Iterate(prefix []byte, fn func(key, value []byte) error) error {
+	for k, v := range s.data {
+		if len(k) < len(prefix) || k[:len(prefix)] != string(prefix) {
+			continue
+		}
+		if err := fn([]byte(k), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memStore) Close() error { return nil }
+
+func TestCompactTreeRootMatchesInclusionProof(t *testing.T) {
+	tree := NewCompactTree(newMemStore())
+
+	const n = 7
+	var root []byte
+	for i := 0; i < n; i++ {
+		var err error
+		_, root, err = tree.Append([]byte(fmt.Sprintf("leaf-%d", i)))
+		if err != nil {
+			t.Fatalf("Append(%d): %v", i, err)
+		}
+	}
+
+	for i := uint64(0); i < n; i++ {
+		leaf, err := tree.leafAt(i)
+		if err != nil {
+			t.Fatalf("leafAt(%d): %v", i, err)
+		}
+		proof, err := tree.InclusionProof(i, n)
+		if err != nil {
+			t.Fatalf("InclusionProof(%d): %v", i, err)
+		}
+		if !VerifyInclusion(root, leaf, proof, i, n) {
+			t.Errorf("VerifyInclusion failed for leaf %d against the tree's own root", i)
+		}
+	}
+}
+
+func TestCompactTreeConsistencyProofNonPowerOfTwoOldSize(t *testing.T) {
+	tree := NewCompactTree(newMemStore())
+
+	const n = 4
+	roots := make([][]byte, n+1)
+	for i := 0; i < n; i++ {
+		_, root, err := tree.Append([]byte(fmt.Sprintf("leaf-%d", i)))
+		if err != nil {
+			t.Fatalf("Append(%d): %v", i, err)
+		}
+		roots[i+1] = root
+	}
+
+	const oldSize, newSize = 3, 4
+	proof, err := tree.ConsistencyProof(oldSize, newSize)
+	if err != nil {
+		t.Fatalf("ConsistencyProof(%d, %d): %v", oldSize, newSize, err)
+	}
+	if !VerifyConsistency(roots[oldSize], roots[newSize], proof, oldSize, newSize) {
+		t.Fatalf("VerifyConsistency(%d, %d) rejected a valid proof", oldSize, newSize)
+	}
+}
+
+func TestCompactTreeConsistencyProofRejectsTamperedRoot(t *testing.T) {
+	tree := NewCompactTree(newMemStore())
+
+	const n = 4
+	var oldRoot, newRoot []byte
+	for i := 0; i < n; i++ {
+		_, root, err := tree.Append([]byte(fmt.Sprintf("leaf-%d", i)))
+		if err != nil {
+			t.Fatalf("Append(%d): %v", i, err)
+		}
+		if i == 2 {
+			oldRoot = root
+		}
+		newRoot = root
+	}
+
+	proof, err := tree.ConsistencyProof(3, 4)
+	if err != nil {
+		t.Fatalf("ConsistencyProof: %v", err)
+	}
+	tampered := append([]byte(nil), newRoot...)
+	tampered[0] ^= 0xff
+	if VerifyConsistency(oldRoot, tampered, proof, 3, 4) {
+		t.Fatal("VerifyConsistency accepted a tampered new root")
+	}
+}
+
+func TestCompactTreeAppendNotPublishedOnFailedWrite(t *testing.T) {
+	store := newMemStore()
+	tree := NewCompactTree(store)
+	if _, _, err := tree.Append([]byte("leaf-0")); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+
+	failing := failingStore{memStore: store}
+	tree.store = failing
+	if _, _, err := tree.Append([]byte("leaf-1")); err == nil {
+		t.Fatal("expected Append to fail")
+	}
+
+	if got := tree.Size(); got != 1 {
+		t.Fatalf("Size() = %d after failed Append, want 1 (in-memory state must not advance past what was durably written)", got)
+	}
+}
+
+type failingStore struct {
+	*memStore
+}
+
+func (failingStore) BatchPut(map[string][]byte) error {
+	return fmt.Errorf("simulated write failure")
+}
+
+func TestCombineFrontierOrder(t *testing.T) {
+	low := []byte("low")
+	mid := []byte("mid")
+	top := []byte("top")
+
+	want := computeNodeHash(top, computeNodeHash(mid, low))
+	got := CombineFrontier(0b111, [][]byte{low, mid, top})
+	if !bytes.Equal(got, want) {
+		t.Fatalf("CombineFrontier combined out of order: got %x, want %x", got, want)
+	}
+}
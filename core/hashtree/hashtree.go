@@ -30,8 +30,6 @@ import (
 	"os"
 	"sync"
 	"syscall"
-
-	"github.com/syndtr/goleveldb/leveldb"
 )
 
 var maxFileSize = 1 << 30 // 1 GiB max file size for memory mapping
@@ -43,10 +41,36 @@ type HashTreeNode struct {
 	Right *HashTreeNode `json:"right,omitempty"` // Right child node
 }
 
-// Compute the hash of a given data slice
+// TreeHashVersion is a version/context byte mixed into every leaf and node
+// hash computed below. Bumping it lets a future hashing scheme be
+// introduced without its roots ever colliding with this one's.
+const TreeHashVersion byte = 0x01
+
+// Domain tags distinguishing a leaf hash from a node hash under
+// TreeHashVersion, so that a leaf hash can never be reinterpreted as a
+// node's left||right concatenation (a second-preimage attack).
+const (
+	legacyLeafDomain = 0x00
+	legacyNodeDomain = 0x01
+)
+
+// computeHash hashes a leaf's raw data, domain-separated from node hashes
+// by legacyLeafDomain.
 func computeHash(data []byte) []byte {
-	hash := sha256.Sum256(data) // Compute SHA-256 hash
-	return hash[:]
+	h := sha256.New()
+	h.Write([]byte{TreeHashVersion, legacyLeafDomain})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// computeNodeHash hashes two sibling hashes into their parent's hash,
+// domain-separated from leaf hashes by legacyNodeDomain.
+func computeNodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{TreeHashVersion, legacyNodeDomain})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
 }
 
 // BuildHashTree builds a Merkle hash tree from leaf nodes.
@@ -70,8 +94,8 @@ func BuildHashTree(leaves [][]byte) *HashTreeNode {
 			if i+1 < len(nodes) {
 				// Combine the hashes of two sibling nodes (left and right).
 				left, right := nodes[i], nodes[i+1]
-				// Concatenate the two hashes and compute the hash of the result to create the parent node.
-				hash := computeHash(append(left.Hash, right.Hash...))
+				// Hash the two sibling hashes together to create the parent node.
+				hash := computeNodeHash(left.Hash, right.Hash)
 				// Append the new parent node to the next level, storing references to its children.
 				nextLevel = append(nextLevel, &HashTreeNode{Hash: hash, Left: left, Right: right})
 			} else {
@@ -108,15 +132,15 @@ func LoadRootHashFromFile(filename string) ([]byte, error) {
 	return ioutil.ReadFile(filename) // Read root hash from file
 }
 
-// SaveLeavesToDB saves leaf node data to LevelDB.
-// The function takes a slice of leaf data (leaves) and stores each leaf in the database (db).
-func SaveLeavesToDB(db *leveldb.DB, leaves [][]byte) error {
-	// Iterate over the leaves to be saved to the database
+// SaveLeavesToDB saves leaf node data to the leaf store.
+// The function takes a slice of leaf data (leaves) and stores each leaf under the store (store).
+func SaveLeavesToDB(store LeafStore, leaves [][]byte) error {
+	// Iterate over the leaves to be saved to the store
 	for i, leaf := range leaves {
 		// Generate a unique key for each leaf using a formatted string with its index
 		key := fmt.Sprintf("leaf-%d", i)
-		// Store the leaf node in LevelDB using the generated key
-		err := db.Put([]byte(key), leaf, nil) // Insert the leaf node into the database
+		// Store the leaf node using the generated key
+		err := store.Put([]byte(key), leaf) // Insert the leaf node into the store
 		// If an error occurs while saving the leaf, return the error
 		if err != nil {
 			return err // Return the error to the caller
@@ -126,9 +150,19 @@ func SaveLeavesToDB(db *leveldb.DB, leaves [][]byte) error {
 	return nil
 }
 
-// Fetch leaf from LevelDB
-func FetchLeafFromDB(db *leveldb.DB, key string) ([]byte, error) {
-	return db.Get([]byte(key), nil) // Retrieve leaf node from LevelDB
+// SaveLeafAtIndex saves a single leaf under its real position in the
+// overall leaf sequence, rather than SaveLeavesToDB's index-within-this-
+// call numbering. Callers that persist one leaf per call must use this
+// instead of SaveLeavesToDB, or every call will overwrite the same
+// "leaf-0" key.
+func SaveLeafAtIndex(store LeafStore, index uint64, leaf []byte) error {
+	key := fmt.Sprintf("leaf-%d", index)
+	return store.Put([]byte(key), leaf)
+}
+
+// Fetch leaf from the leaf store
+func FetchLeafFromDB(store LeafStore, key string) ([]byte, error) {
+	return store.Get([]byte(key)) // Retrieve leaf node from the store
 }
 
 // Print the root hash of the hash tree
@@ -136,45 +170,43 @@ func PrintRootHash(root *HashTreeNode) {
 	fmt.Printf("Root Hash: %x\n", root.Hash) // Print root hash
 }
 
-// PruneOldLeaves removes old leaf nodes from the LevelDB.
-// It takes a specified number of leaves (numLeaves) and deletes them by key from the database.
-func PruneOldLeaves(db *leveldb.DB, numLeaves int) error {
+// PruneOldLeaves removes old leaf nodes from the leaf store.
+// It takes a specified number of leaves (numLeaves) and deletes them by key from the store.
+func PruneOldLeaves(store LeafStore, numLeaves int) error {
 	// Loop over the number of leaves to be deleted
 	for i := 0; i < numLeaves; i++ {
 		// Generate the key for the leaf node using a formatted string
 		key := fmt.Sprintf("leaf-%d", i)
 		// Attempt to delete the leaf node by key
-		err := db.Delete([]byte(key), nil) // Remove old leaf node
-		// If an error occurs, return it, except for the ErrNotFound case (ignore if key not found)
-		if err != nil && err != leveldb.ErrNotFound {
-			return err // Return any error other than 'not found'
+		err := store.Delete([]byte(key)) // Remove old leaf node
+		// If an error occurs, return it
+		if err != nil {
+			return err // Return the error to the caller
 		}
 	}
 	// Return nil if the operation completes successfully without errors
 	return nil
 }
 
-// SaveLeavesBatchToDB performs batch operations for LevelDB to save leaf nodes efficiently.
-// Using a batch operation improves performance by reducing the number of write calls to the database.
-func SaveLeavesBatchToDB(db *leveldb.DB, leaves [][]byte) error {
-	// Create a new batch to accumulate multiple write operations
-	batch := new(leveldb.Batch)
-	// Iterate over the leaves to be added
+// SaveLeavesBatchToDB performs a batched write to the leaf store to save leaf nodes efficiently.
+// Using a batch operation improves performance by reducing the number of write calls to the store.
+func SaveLeavesBatchToDB(store LeafStore, leaves [][]byte) error {
+	// Accumulate the leaves into a single batch of key/value pairs
+	pairs := make(map[string][]byte, len(leaves))
 	for i, leaf := range leaves {
 		// Generate the key for each leaf node using a formatted string
 		key := fmt.Sprintf("leaf-%d", i)
-		// Add the leaf node to the batch
-		batch.Put([]byte(key), leaf) // Queue the leaf for batch write
+		pairs[key] = leaf
 	}
-	// Execute the batch write to LevelDB, applying all queued operations at once
-	return db.Write(batch, nil) // Write the batch to the database
+	// Execute the batch write, applying all queued operations at once
+	return store.BatchPut(pairs)
 }
 
-// FetchLeafConcurrent retrieves a leaf node from LevelDB while ensuring it handles concurrent access safely.
-// In this example, concurrency is handled implicitly by the LevelDB API, which can manage simultaneous read operations.
-func FetchLeafConcurrent(db *leveldb.DB, key string) ([]byte, error) {
-	// Retrieve the leaf node from LevelDB using its key
-	return db.Get([]byte(key), nil) // Fetch the leaf data
+// FetchLeafConcurrent retrieves a leaf node from the leaf store while ensuring it handles concurrent access safely.
+// Concurrency is handled implicitly by the store implementation, which can manage simultaneous read operations.
+func FetchLeafConcurrent(store LeafStore, key string) ([]byte, error) {
+	// Retrieve the leaf node from the store using its key
+	return store.Get([]byte(key)) // Fetch the leaf data
 }
 
 // setMaxFileSize updates the global maxFileSize variable based on the provided size in GiB (gibibytes).
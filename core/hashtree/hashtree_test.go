@@ -0,0 +1,69 @@
+// MIT License
+//
+// Copyright (c) 2024 sphinx-core
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package hashtree
+
+import "testing"
+
+func TestHashLeafAndHashNodeAreDomainSeparated(t *testing.T) {
+	data := []byte("some node's left||right, reinterpreted as leaf data")
+	leafHash := computeHash(data)
+	nodeHash := computeNodeHash(data[:len(data)/2], data[len(data)/2:])
+
+	if string(leafHash) == string(nodeHash) {
+		t.Fatal("leaf and node hashes collided; domain separation is not preventing a second-preimage reinterpretation")
+	}
+}
+
+func TestHashLeafExportedWrapperMatchesBuildHashTree(t *testing.T) {
+	leaves := [][]byte{[]byte("only-leaf")}
+	root := BuildHashTree(leaves)
+
+	if got := HashLeaf(leaves[0]); string(got) != string(root.Hash) {
+		t.Fatalf("HashLeaf(%q) = %x, want %x to match BuildHashTree's single-leaf root (both must use the same hashing scheme)", leaves[0], got, root.Hash)
+	}
+}
+
+func TestHashNodeExportedWrapperMatchesBuildHashTree(t *testing.T) {
+	leaves := [][]byte{[]byte("left"), []byte("right")}
+	root := BuildHashTree(leaves)
+
+	got := HashNode(computeHash(leaves[0]), computeHash(leaves[1]))
+	if string(got) != string(root.Hash) {
+		t.Fatalf("HashNode(...) = %x, want %x to match BuildHashTree's two-leaf root", got, root.Hash)
+	}
+}
+
+func TestSaveLeafAtIndexKeyIsIndependentOfCallOrder(t *testing.T) {
+	store := newMemStore()
+
+	if err := SaveLeafAtIndex(store, 5, []byte("fifth")); err != nil {
+		t.Fatalf("SaveLeafAtIndex: %v", err)
+	}
+	got, err := FetchLeafFromDB(store, "leaf-5")
+	if err != nil {
+		t.Fatalf("FetchLeafFromDB: %v", err)
+	}
+	if string(got) != "fifth" {
+		t.Fatalf("leaf-5 = %q, want %q", got, "fifth")
+	}
+}
@@ -0,0 +1,353 @@
+// MIT License
+//
+// Copyright (c) 2024 sphinx-core
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package monitor tails a signer's leaf store and independently recomputes
+// the Merkle root as new leaves appear, the way a Certificate Transparency
+// monitor continuously audits a log without needing to trust the log
+// operator. A mismatch against the signer's own checkpoint, or a gap or
+// reordering in leaf indices, is reported as a TamperEvent rather than
+// silently ignored.
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sphinx-core/sphinx-master/core/hashtree"
+)
+
+// cursorKey is where the monitor persists how many leaves it has tailed
+// so far, so a restart resumes rather than re-tailing from the start.
+const cursorKey = "monitor:cursor"
+
+// maxLogSize is the size at or above which an existing tamper log is
+// rotated to a ".1" suffix when Run opens it. The log is not rotated while
+// Run is running, so it can grow past this size during a single run.
+const maxLogSize = 10 << 20 // 10 MiB
+
+// defaultPollInterval is Run's polling cadence when Config.PollInterval
+// is unset, and the basis gapTimeout falls back to when Config.PollInterval
+// is also unset.
+const defaultPollInterval = 5 * time.Second
+
+// maxGapLookahead bounds how many leaf indices past cursor tailOnce will
+// probe for when deciding whether cursor is merely not-yet-written or
+// actually missing a leaf that a later index already has. This keeps gap
+// detection a handful of point lookups instead of a scan over every leaf
+// the store has ever held.
+const maxGapLookahead = 1024
+
+// TamperEvent describes a detected discrepancy between the signer's
+// claimed state and what the monitor independently computed from the
+// leaves it tailed.
+type TamperEvent struct {
+	ExpectedRoot []byte
+	ObservedRoot []byte
+	LeafIndex    uint64
+	Reason       string
+}
+
+// AlertHook lets operators plug in their own alerting transport (webhook,
+// syslog, pager, ...) without the monitor needing to know about it,
+// mirroring the pluggable verification hooks callers register with
+// x509util for certificate validation failures.
+type AlertHook interface {
+	Alert(TamperEvent)
+}
+
+// CheckpointSource returns the tree size and root hash of the checkpoint
+// the monitor should treat as the signer's latest claimed state, e.g. a
+// thin wrapper around sign.LastCheckpoint.
+type CheckpointSource func() (treeSize uint64, rootHash []byte, err error)
+
+// Config configures Run.
+type Config struct {
+	// Store is the signer's leaf store, opened independently by the
+	// monitor; Run only ever reads from it (plus its own cursor key).
+	Store hashtree.LeafStore
+	// PollInterval is how often Run checks for new leaves. Defaults to
+	// defaultPollInterval if unset.
+	PollInterval time.Duration
+	// CheckpointSource supplies the checkpoint to compare against. If
+	// nil, the monitor still detects leaf gaps/reordering but cannot
+	// detect a root mismatch against a checkpoint.
+	CheckpointSource CheckpointSource
+	// Events, if non-nil, receives every TamperEvent detected.
+	Events chan<- TamperEvent
+	// Hook, if non-nil, is called synchronously for every TamperEvent.
+	Hook AlertHook
+	// LogPath, if set, appends every TamperEvent to a rotating on-disk
+	// log at this path.
+	LogPath string
+	// GapTimeout is how long tailOnce waits for a specific leaf index
+	// before treating it as a gap or reordering rather than a signer that
+	// simply hasn't written it yet. Defaults to 3*PollInterval if unset
+	// (falling back to 3*defaultPollInterval if PollInterval is also
+	// unset).
+	GapTimeout time.Duration
+
+	// now returns the current time; overridden in tests so GapTimeout can
+	// be exercised without a real sleep. Defaults to time.Now when nil.
+	now func() time.Time
+}
+
+func (c Config) clock() func() time.Time {
+	if c.now != nil {
+		return c.now
+	}
+	return time.Now
+}
+
+func (c Config) gapTimeout() time.Duration {
+	if c.GapTimeout > 0 {
+		return c.GapTimeout
+	}
+	interval := c.PollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	return 3 * interval
+}
+
+// frontier is an in-memory-only copy of the compact range merge algorithm
+// used by hashtree.CompactTree. The monitor recomputes it independently
+// from the leaves it tails rather than trusting any persisted tree state,
+// since the whole point is to not require the signer to be trusted.
+type frontier struct {
+	size  uint64
+	nodes [][]byte
+
+	// stallIndex and stallSince track how long tailOnce has been waiting
+	// on this specific leaf index while a later index is already present
+	// in the store. They reset whenever the wait is for a different index
+	// or the cursor advances, so the timeout is per-gap, not cumulative.
+	stallIndex uint64
+	stallSince time.Time
+}
+
+func (f *frontier) append(leafHash []byte) {
+	hash := leafHash
+	level := 0
+	for (f.size>>uint(level))&1 == 1 {
+		hash = hashtree.HashNode(f.nodes[level], hash)
+		level++
+	}
+	if level == len(f.nodes) {
+		f.nodes = append(f.nodes, hash)
+	} else {
+		f.nodes[level] = hash
+	}
+	f.size++
+}
+
+func (f *frontier) root() []byte {
+	return hashtree.CombineFrontier(f.size, f.nodes)
+}
+
+// Run tails cfg.Store for new leaves until ctx is cancelled, recomputing
+// the Merkle root after every batch of new leaves and comparing it
+// against cfg.CheckpointSource whenever the tailed size catches up to a
+// checkpointed size.
+func Run(ctx context.Context, cfg Config) error {
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaultPollInterval
+	}
+
+	var logFile *os.File
+	if cfg.LogPath != "" {
+		f, err := openTamperLog(cfg.LogPath)
+		if err != nil {
+			return fmt.Errorf("opening tamper log: %w", err)
+		}
+		defer f.Close()
+		logFile = f
+	}
+
+	cursor, err := loadCursor(cfg.Store)
+	if err != nil {
+		return fmt.Errorf("loading monitor cursor: %w", err)
+	}
+
+	f := &frontier{}
+	for i := uint64(0); i < cursor; i++ {
+		leaf, err := cfg.Store.Get(leafKey(i))
+		if err != nil {
+			return fmt.Errorf("replaying leaf %d: %w", i, err)
+		}
+		f.append(hashtree.HashLeaf(leaf))
+	}
+
+	ticker := time.NewTicker(cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		if err := tailOnce(cfg, f, &cursor, logFile); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// tailOnce reads every new sequentially-indexed leaf since cursor,
+// updates the in-memory frontier, persists the new cursor, and checks
+// the recomputed root against the latest checkpoint if the tailed size
+// has caught up to one. If cursor itself is missing but a later leaf
+// index is already present, that's a gap or reordering rather than the
+// monitor simply being caught up; tailOnce reports it once the wait
+// outlasts cfg.gapTimeout().
+func tailOnce(cfg Config, f *frontier, cursor *uint64, logFile *os.File) error {
+	for {
+		leaf, err := cfg.Store.Get(leafKey(*cursor))
+		if err == hashtree.ErrNotFound {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("reading leaf %d: %w", *cursor, err)
+		}
+		f.append(hashtree.HashLeaf(leaf))
+		*cursor++
+		f.stallSince = time.Time{}
+	}
+
+	laterIndex, found, err := nextLeafIndexAfter(cfg.Store, *cursor)
+	if err != nil {
+		return fmt.Errorf("scanning for leaf indices past %d: %w", *cursor, err)
+	}
+	if !found {
+		f.stallSince = time.Time{}
+	} else {
+		now := cfg.clock()()
+		if f.stallSince.IsZero() || f.stallIndex != *cursor {
+			f.stallIndex = *cursor
+			f.stallSince = now
+		} else if now.Sub(f.stallSince) >= cfg.gapTimeout() {
+			report(cfg, logFile, TamperEvent{
+				LeafIndex: *cursor,
+				Reason:    fmt.Sprintf("leaf %d still missing after %s while leaf %d is present (gap or reordering)", *cursor, cfg.gapTimeout(), laterIndex),
+			})
+			f.stallSince = now
+		}
+	}
+
+	if err := saveCursor(cfg.Store, *cursor); err != nil {
+		return fmt.Errorf("persisting monitor cursor: %w", err)
+	}
+
+	if cfg.CheckpointSource == nil {
+		return nil
+	}
+	treeSize, expectedRoot, err := cfg.CheckpointSource()
+	if err != nil {
+		return fmt.Errorf("reading checkpoint: %w", err)
+	}
+	if treeSize != f.size {
+		// The checkpoint hasn't caught up to (or has moved past) what
+		// we've tailed so far; nothing to compare yet.
+		return nil
+	}
+
+	observedRoot := f.root()
+	if !bytes.Equal(observedRoot, expectedRoot) {
+		report(cfg, logFile, TamperEvent{
+			ExpectedRoot: expectedRoot,
+			ObservedRoot: observedRoot,
+			LeafIndex:    f.size - 1,
+			Reason:       "root mismatch at checkpointed tree size",
+		})
+	}
+	return nil
+}
+
+func report(cfg Config, logFile *os.File, event TamperEvent) {
+	if cfg.Events != nil {
+		cfg.Events <- event
+	}
+	if cfg.Hook != nil {
+		cfg.Hook.Alert(event)
+	}
+	if logFile != nil {
+		writeTamperLog(logFile, event)
+	}
+}
+
+func leafKey(index uint64) []byte {
+	return []byte(fmt.Sprintf("leaf-%d", index))
+}
+
+// nextLeafIndexAfter looks for the smallest leaf index strictly greater
+// than after that store already has, checking at most maxGapLookahead
+// candidate indices with direct Gets rather than scanning every leaf key
+// the store has ever held. A gap whose next present leaf lies more than
+// maxGapLookahead indices past it is not found here and so is not
+// reported; tailOnce simply stays stalled at the missing index.
+func nextLeafIndexAfter(store hashtree.LeafStore, after uint64) (uint64, bool, error) {
+	for offset := uint64(1); offset <= maxGapLookahead; offset++ {
+		index := after + offset
+		_, err := store.Get(leafKey(index))
+		if err == hashtree.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return 0, false, err
+		}
+		return index, true, nil
+	}
+	return 0, false, nil
+}
+
+func loadCursor(store hashtree.LeafStore) (uint64, error) {
+	val, err := store.Get([]byte(cursorKey))
+	if err == hashtree.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(val), nil
+}
+
+func saveCursor(store hashtree.LeafStore, cursor uint64) error {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, cursor)
+	return store.Put([]byte(cursorKey), buf)
+}
+
+func openTamperLog(path string) (*os.File, error) {
+	if info, err := os.Stat(path); err == nil && info.Size() >= maxLogSize {
+		if err := os.Rename(path, path+".1"); err != nil {
+			return nil, fmt.Errorf("rotating tamper log: %w", err)
+		}
+	}
+	return os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+}
+
+func writeTamperLog(f *os.File, event TamperEvent) {
+	fmt.Fprintf(f, "%d tamper leaf=%d reason=%q expected=%x observed=%x\n",
+		time.Now().Unix(), event.LeafIndex, event.Reason, event.ExpectedRoot, event.ObservedRoot)
+}
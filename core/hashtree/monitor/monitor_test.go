@@ -0,0 +1,244 @@
+// MIT License
+//
+// Copyright (c) 2024 sphinx-core
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package monitor
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sphinx-core/sphinx-master/core/hashtree"
+)
+
+// memStore is a minimal in-memory hashtree.LeafStore for tailOnce tests.
+type memStore struct {
+	data map[string][]byte
+}
+
+func newMemStore() *memStore {
+	return &memStore{data: make(map[string][]byte)}
+}
+
+func (s *memStore) Put(key, value []byte) error {
+	s.data[string(key)] = append([]byte(nil), value...)
+	return nil
+}
+
+func (s *memStore) Get(key []byte) ([]byte, error) {
+	v, ok := s.data[string(key)]
+	if !ok {
+		return nil, hashtree.ErrNotFound
+	}
+	return v, nil
+}
+
+func (s *memStore) Delete(key []byte) error {
+	delete(s.data, string(key))
+	return nil
+}
+
+func (s *memStore) BatchPut(pairs map[string][]byte) error {
+	for k, v := range pairs {
+		s.data[k] = append([]byte(nil), v...)
+	}
+	return nil
+}
+
+func (s *memStore) Iterate(prefix []byte, fn func(key, value []byte) error) error {
+	for k, v := range s.data {
+		if len(k) < len(prefix) || k[:len(prefix)] != string(prefix) {
+			continue
+		}
+		if err := fn([]byte(k), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memStore) Close() error { return nil }
+
+func seedLeaves(t *testing.T, store *memStore, n int) []byte {
+	t.Helper()
+	tree := hashtree.NewCompactTree(store)
+	var root []byte
+	for i := 0; i < n; i++ {
+		var err error
+		_, root, err = tree.Append([]byte(fmt.Sprintf("leaf-%d", i)))
+		if err != nil {
+			t.Fatalf("seeding leaf %d: %v", i, err)
+		}
+	}
+	return root
+}
+
+func TestTailOnceMatchesCheckpointRoot(t *testing.T) {
+	store := newMemStore()
+	root := seedLeaves(t, store, 4)
+
+	events := make(chan TamperEvent, 1)
+	cfg := Config{
+		Store:  store,
+		Events: events,
+		CheckpointSource: func() (uint64, []byte, error) {
+			return 4, root, nil
+		},
+	}
+
+	f := &frontier{}
+	cursor := uint64(0)
+	if err := tailOnce(cfg, f, &cursor, nil); err != nil {
+		t.Fatalf("tailOnce: %v", err)
+	}
+
+	select {
+	case ev := <-events:
+		t.Fatalf("unexpected tamper event for a matching checkpoint: %+v", ev)
+	default:
+	}
+}
+
+func TestTailOnceDetectsRootMismatch(t *testing.T) {
+	store := newMemStore()
+	seedLeaves(t, store, 4)
+
+	wrongRoot := []byte("not-the-real-root-not-the-real!")
+	events := make(chan TamperEvent, 1)
+	cfg := Config{
+		Store:  store,
+		Events: events,
+		CheckpointSource: func() (uint64, []byte, error) {
+			return 4, wrongRoot, nil
+		},
+	}
+
+	f := &frontier{}
+	cursor := uint64(0)
+	if err := tailOnce(cfg, f, &cursor, nil); err != nil {
+		t.Fatalf("tailOnce: %v", err)
+	}
+
+	select {
+	case ev := <-events:
+		if ev.Reason == "" {
+			t.Fatal("expected a TamperEvent with a reason set")
+		}
+	default:
+		t.Fatal("expected a TamperEvent for a mismatched root, got none")
+	}
+}
+
+func TestTailOnceDetectsGapAfterTimeout(t *testing.T) {
+	store := newMemStore()
+	// leaf-1 is written but leaf-0 is missing, simulating the signer
+	// writing an index out of order (or skipping one entirely).
+	if err := store.Put([]byte("leaf-1"), []byte("leaf-1-data")); err != nil {
+		t.Fatalf("seeding leaf 1: %v", err)
+	}
+
+	clock := time.Now()
+	events := make(chan TamperEvent, 1)
+	cfg := Config{
+		Store:      store,
+		Events:     events,
+		GapTimeout: time.Second,
+		now:        func() time.Time { return clock },
+	}
+
+	f := &frontier{}
+	cursor := uint64(0)
+	if err := tailOnce(cfg, f, &cursor, nil); err != nil {
+		t.Fatalf("tailOnce (first poll): %v", err)
+	}
+	select {
+	case ev := <-events:
+		t.Fatalf("unexpected tamper event before GapTimeout elapsed: %+v", ev)
+	default:
+	}
+
+	clock = clock.Add(cfg.GapTimeout + time.Millisecond)
+	if err := tailOnce(cfg, f, &cursor, nil); err != nil {
+		t.Fatalf("tailOnce (second poll): %v", err)
+	}
+
+	select {
+	case ev := <-events:
+		if ev.LeafIndex != 0 {
+			t.Fatalf("TamperEvent.LeafIndex = %d, want 0", ev.LeafIndex)
+		}
+		if ev.Reason == "" {
+			t.Fatal("expected a TamperEvent with a reason set")
+		}
+	default:
+		t.Fatal("expected a TamperEvent for a leaf gap that outlasted GapTimeout")
+	}
+}
+
+func TestTailOnceNoGapEventWhenFullyCaughtUp(t *testing.T) {
+	store := newMemStore()
+	seedLeaves(t, store, 4)
+
+	clock := time.Now()
+	events := make(chan TamperEvent, 1)
+	cfg := Config{
+		Store:      store,
+		Events:     events,
+		GapTimeout: time.Second,
+		now:        func() time.Time { return clock },
+	}
+
+	f := &frontier{}
+	cursor := uint64(0)
+	if err := tailOnce(cfg, f, &cursor, nil); err != nil {
+		t.Fatalf("tailOnce (first poll): %v", err)
+	}
+
+	clock = clock.Add(cfg.GapTimeout + time.Millisecond)
+	if err := tailOnce(cfg, f, &cursor, nil); err != nil {
+		t.Fatalf("tailOnce (second poll): %v", err)
+	}
+
+	select {
+	case ev := <-events:
+		t.Fatalf("unexpected tamper event when every leaf up to cursor is present: %+v", ev)
+	default:
+	}
+}
+
+func TestFrontierRootMatchesCompactTree(t *testing.T) {
+	store := newMemStore()
+	want := seedLeaves(t, store, 7)
+
+	f := &frontier{}
+	for i := 0; i < 7; i++ {
+		leaf, err := store.Get([]byte(fmt.Sprintf("leaf-%d", i)))
+		if err != nil {
+			t.Fatalf("reading leaf %d: %v", i, err)
+		}
+		f.append(hashtree.HashLeaf(leaf))
+	}
+
+	if got := f.root(); string(got) != string(want) {
+		t.Fatalf("frontier.root() = %x, want %x (must match hashtree.CombineFrontier's order)", got, want)
+	}
+}
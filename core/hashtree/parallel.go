@@ -0,0 +1,279 @@
+// MIT License
+//
+// Copyright (c) 2024 sphinx-core
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package hashtree
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// largeLevelThreshold is the default SpillThreshold: the node count at or
+// above which a committed level is written to disk and its nodes stripped
+// down to their hashes. Spilled levels are not read back from disk;
+// SafeMemoryMapFile is only used to confirm the spill file was written.
+const largeLevelThreshold = 1 << 16
+
+// Hasher is a pure, stateless SHA-256 hasher for tree construction.
+// computeNodeHash writes a node's children to the running hash
+// separately, so unlike the original BuildHashTree there is no
+// append(left, right...) concatenation to allocate or pool per node.
+type Hasher struct{}
+
+// NewHasher creates a Hasher ready for concurrent use.
+func NewHasher() *Hasher {
+	return &Hasher{}
+}
+
+// HashLeaf hashes a single leaf's data.
+func (h *Hasher) HashLeaf(data []byte) []byte {
+	return computeHash(data)
+}
+
+// HashNode hashes a pair of sibling hashes into their parent's hash.
+func (h *Hasher) HashNode(left, right []byte) []byte {
+	return computeNodeHash(left, right)
+}
+
+// CommitOptions configures BuildHashTreeParallel.
+type CommitOptions struct {
+	// Store, if non-nil, is where completed interior nodes are batched to
+	// when PersistInteriorNodes is true.
+	Store LeafStore
+	// Workers is the number of goroutines hashing sibling pairs at each
+	// level. A value <= 0 defaults to runtime.GOMAXPROCS(0).
+	Workers int
+	// BatchSize is how many interior node writes are buffered before a
+	// batch is flushed to Store. A value <= 0 defaults to 1024.
+	BatchSize int
+	// PersistInteriorNodes, when true, writes every interior node's hash
+	// to Store as each level completes.
+	PersistInteriorNodes bool
+	// SpillDir, if set, is the directory large levels are spilled to. Once
+	// a level is written out, its nodes are reduced to their hash alone
+	// (Left/Right dropped), which drops the entire subtree beneath them
+	// from the Go heap since nothing else references it. It is only used
+	// when a level has at least SpillThreshold nodes.
+	SpillDir string
+	// SpillThreshold is the node count at or above which a committed level is
+	// spilled per SpillDir. A value <= 0 defaults to largeLevelThreshold.
+	SpillThreshold int
+}
+
+func (o CommitOptions) workers() int {
+	if o.Workers > 0 {
+		return o.Workers
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+func (o CommitOptions) batchSize() int {
+	if o.BatchSize > 0 {
+		return o.BatchSize
+	}
+	return 1024
+}
+
+func (o CommitOptions) spillThreshold() int {
+	if o.SpillThreshold > 0 {
+		return o.SpillThreshold
+	}
+	return largeLevelThreshold
+}
+
+// Committer walks a tree level-by-level, hashing sibling pairs across a
+// worker pool and, when configured, persisting interior nodes to a
+// LeafStore in batches as each level completes.
+type Committer struct {
+	hasher *Hasher
+	opts   CommitOptions
+}
+
+// NewCommitter creates a Committer with the given options.
+func NewCommitter(opts CommitOptions) *Committer {
+	return &Committer{hasher: NewHasher(), opts: opts}
+}
+
+// pairTask is one unit of work: hash the pair of nodes at the given index
+// in the current level (or carry the lone node if it has no sibling).
+type pairTask struct {
+	index int
+	left  *HashTreeNode
+	right *HashTreeNode // nil if left is an odd carry with no sibling
+}
+
+type pairResult struct {
+	index  int
+	parent *HashTreeNode
+	hash   []byte
+}
+
+// Commit builds a tree from nodes by repeatedly hashing sibling pairs one
+// level at a time, dispatching each level's pairs over a bounded channel
+// to a fixed worker pool.
+func (c *Committer) Commit(nodes []*HashTreeNode) (*HashTreeNode, error) {
+	level := 0
+	for len(nodes) > 1 {
+		next, err := c.commitLevel(level, nodes)
+		if err != nil {
+			return nil, fmt.Errorf("committing level %d: %w", level, err)
+		}
+		nodes = next
+		level++
+	}
+	return nodes[0], nil
+}
+
+// commitLevel hashes every sibling pair in nodes concurrently and, if
+// configured, persists the results and spills them to disk once the level
+// is large enough that keeping it all resident would be wasteful.
+func (c *Committer) commitLevel(level int, nodes []*HashTreeNode) ([]*HashTreeNode, error) {
+	pairCount := (len(nodes) + 1) / 2
+	tasks := make(chan pairTask, c.opts.workers())
+	results := make(chan pairResult, c.opts.workers())
+
+	var wg sync.WaitGroup
+	for w := 0; w < c.opts.workers(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range tasks {
+				if t.right == nil {
+					results <- pairResult{index: t.index, parent: t.left}
+					continue
+				}
+				hash := c.hasher.HashNode(t.left.Hash, t.right.Hash)
+				results <- pairResult{
+					index:  t.index,
+					parent: &HashTreeNode{Hash: hash, Left: t.left, Right: t.right},
+					hash:   hash,
+				}
+			}
+		}()
+	}
+
+	go func() {
+		for i, idx := 0, 0; i < len(nodes); i, idx = i+2, idx+1 {
+			if i+1 < len(nodes) {
+				tasks <- pairTask{index: idx, left: nodes[i], right: nodes[i+1]}
+			} else {
+				tasks <- pairTask{index: idx, left: nodes[i]}
+			}
+		}
+		close(tasks)
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	next := make([]*HashTreeNode, pairCount)
+	batch := make(map[string][]byte, c.opts.batchSize())
+	for res := range results {
+		next[res.index] = res.parent
+		if c.opts.PersistInteriorNodes && c.opts.Store != nil && res.hash != nil {
+			batch[fmt.Sprintf("node-%d-%d", level+1, res.index)] = res.hash
+			if len(batch) >= c.opts.batchSize() {
+				if err := c.opts.Store.BatchPut(batch); err != nil {
+					return nil, err
+				}
+				batch = make(map[string][]byte, c.opts.batchSize())
+			}
+		}
+	}
+	if len(batch) > 0 {
+		if err := c.opts.Store.BatchPut(batch); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.opts.PersistInteriorNodes && c.opts.SpillDir != "" && len(next) >= c.opts.spillThreshold() {
+		if err := spillLevel(c.opts.SpillDir, level+1, next); err != nil {
+			return nil, err
+		}
+	}
+
+	return next, nil
+}
+
+// spillLevel writes a level's node hashes to a flat file, confirms the
+// write by memory-mapping it back in, and then strips every node in nodes
+// down to its Hash alone. Dropping Left/Right is what actually frees the
+// level: nodes is the only thing still referencing the subtree beneath it
+// (Commit's nodes variable was already reassigned to this level by the
+// time this runs), so once these pointers are gone that whole subtree is
+// unreachable and the next GC reclaims it.
+func spillLevel(dir string, level int, nodes []*HashTreeNode) error {
+	path := fmt.Sprintf("%s/level-%d.bin", dir, level)
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("creating spill file: %w", err)
+	}
+	for _, n := range nodes {
+		if _, err := f.Write(n.Hash); err != nil {
+			f.Close()
+			return fmt.Errorf("writing spill file: %w", err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("closing spill file: %w", err)
+	}
+
+	mapped, err := SafeMemoryMapFile(path)
+	if err != nil {
+		return fmt.Errorf("mapping spill file: %w", err)
+	}
+	if err := SafeUnmapFile(mapped); err != nil {
+		return err
+	}
+
+	for i, n := range nodes {
+		nodes[i] = &HashTreeNode{Hash: n.Hash}
+	}
+	return nil
+}
+
+// BuildHashTreeParallel builds a hash tree the same way BuildHashTree
+// does, but hashes each level's sibling pairs across a worker pool sized
+// by opts instead of on a single goroutine. On large leaf sets this
+// gives near-linear speedup with worker count and, when
+// opts.PersistInteriorNodes and opts.SpillDir are both set, lets
+// fully-committed levels at or above opts.SpillThreshold be dropped from
+// the Go heap once they're written to disk, instead of kept resident for
+// the rest of the build.
+func BuildHashTreeParallel(leaves [][]byte, opts CommitOptions) (*HashTreeNode, error) {
+	if len(leaves) == 0 {
+		return nil, fmt.Errorf("hashtree: cannot build a tree from zero leaves")
+	}
+
+	hasher := NewHasher()
+	nodes := make([]*HashTreeNode, len(leaves))
+	for i, leaf := range leaves {
+		nodes[i] = &HashTreeNode{Hash: hasher.HashLeaf(leaf)}
+	}
+
+	committer := &Committer{hasher: hasher, opts: opts}
+	return committer.Commit(nodes)
+}
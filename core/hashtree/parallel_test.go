@@ -0,0 +1,116 @@
+// MIT License
+//
+// Copyright (c) 2024 sphinx-core
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package hashtree
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestBuildHashTreeParallelMatchesBuildHashTree(t *testing.T) {
+	leaves := make([][]byte, 13)
+	for i := range leaves {
+		leaves[i] = []byte(fmt.Sprintf("leaf-%d", i))
+	}
+
+	want := BuildHashTree(leaves)
+	got, err := BuildHashTreeParallel(leaves, CommitOptions{Workers: 4})
+	if err != nil {
+		t.Fatalf("BuildHashTreeParallel: %v", err)
+	}
+
+	if !bytes.Equal(got.Hash, want.Hash) {
+		t.Fatalf("BuildHashTreeParallel root = %x, want %x", got.Hash, want.Hash)
+	}
+}
+
+func TestBuildHashTreeParallelPersistsInteriorNodes(t *testing.T) {
+	leaves := [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d")}
+	store := newMemStore()
+
+	_, err := BuildHashTreeParallel(leaves, CommitOptions{
+		Store:                store,
+		Workers:              2,
+		BatchSize:            1,
+		PersistInteriorNodes: true,
+	})
+	if err != nil {
+		t.Fatalf("BuildHashTreeParallel: %v", err)
+	}
+
+	if _, err := store.Get([]byte("node-1-0")); err != nil {
+		t.Fatalf("expected level-1 interior node to be persisted: %v", err)
+	}
+}
+
+func TestBuildHashTreeParallelRejectsEmptyInput(t *testing.T) {
+	if _, err := BuildHashTreeParallel(nil, CommitOptions{}); err == nil {
+		t.Fatal("expected an error for zero leaves")
+	}
+}
+
+// countReachable walks Left/Right from root and counts every node still
+// reachable, which is what a spill that actually drops pointers should
+// shrink relative to a build that keeps every node resident.
+func countReachable(n *HashTreeNode) int {
+	if n == nil {
+		return 0
+	}
+	return 1 + countReachable(n.Left) + countReachable(n.Right)
+}
+
+func TestBuildHashTreeParallelSpillDropsCommittedLevelPointers(t *testing.T) {
+	leaves := make([][]byte, 8)
+	for i := range leaves {
+		leaves[i] = []byte(fmt.Sprintf("leaf-%d", i))
+	}
+	store := newMemStore()
+
+	full, err := BuildHashTreeParallel(leaves, CommitOptions{Store: store, PersistInteriorNodes: true})
+	if err != nil {
+		t.Fatalf("BuildHashTreeParallel (no spill): %v", err)
+	}
+	fullCount := countReachable(full)
+	if want := 2*len(leaves) - 1; fullCount != want {
+		t.Fatalf("reachable nodes without spilling = %d, want %d", fullCount, want)
+	}
+
+	spilled, err := BuildHashTreeParallel(leaves, CommitOptions{
+		Store:                store,
+		PersistInteriorNodes: true,
+		SpillDir:             t.TempDir(),
+		SpillThreshold:       3,
+	})
+	if err != nil {
+		t.Fatalf("BuildHashTreeParallel (spill): %v", err)
+	}
+	if !bytes.Equal(spilled.Hash, full.Hash) {
+		t.Fatalf("spilled root = %x, want %x", spilled.Hash, full.Hash)
+	}
+
+	spilledCount := countReachable(spilled)
+	if spilledCount >= fullCount {
+		t.Fatalf("reachable nodes after spilling = %d, want fewer than %d (the unspilled count)", spilledCount, fullCount)
+	}
+}
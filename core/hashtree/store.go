@@ -0,0 +1,127 @@
+// MIT License
+//
+// Copyright (c) 2024 sphinx-core
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package hashtree
+
+import (
+	"errors"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// ErrNotFound is returned by a LeafStore's Get when key does not exist,
+// regardless of which backend is in use.
+var ErrNotFound = errors.New("hashtree: key not found")
+
+// LeafStore abstracts the key/value store backing leaf and Merkle tree
+// persistence, so SaveLeavesToDB, CompactTree and SphincsManager can run
+// against whichever storage engine fits the workload (goleveldb, BadgerDB,
+// ...) without change.
+type LeafStore interface {
+	// Put writes value under key, overwriting any existing value.
+	Put(key, value []byte) error
+	// Get reads the value stored under key. It returns ErrNotFound when
+	// the key does not exist.
+	Get(key []byte) ([]byte, error)
+	// Delete removes key. It is a no-op if the key does not exist.
+	Delete(key []byte) error
+	// BatchPut writes all of pairs atomically.
+	BatchPut(pairs map[string][]byte) error
+	// Iterate calls fn for every key with the given prefix, in key order.
+	// Iteration stops and returns fn's error if fn returns a non-nil error.
+	Iterate(prefix []byte, fn func(key, value []byte) error) error
+	// Close releases the underlying storage handle.
+	Close() error
+}
+
+// LevelDBStore is a LeafStore backed by goleveldb, the store this package
+// has always used.
+type LevelDBStore struct {
+	db *leveldb.DB
+}
+
+// NewLevelDBStore wraps an already-open LevelDB handle as a LeafStore.
+func NewLevelDBStore(db *leveldb.DB) *LevelDBStore {
+	return &LevelDBStore{db: db}
+}
+
+// OpenLevelDBStore opens (creating if necessary) a LevelDB database at path
+// and wraps it as a LeafStore.
+func OpenLevelDBStore(path string) (*LevelDBStore, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return NewLevelDBStore(db), nil
+}
+
+// DB returns the underlying *leveldb.DB, for callers that still need direct
+// access (e.g. to pass to leveldb-specific tooling).
+func (s *LevelDBStore) DB() *leveldb.DB {
+	return s.db
+}
+
+func (s *LevelDBStore) Put(key, value []byte) error {
+	return s.db.Put(key, value, nil)
+}
+
+func (s *LevelDBStore) Get(key []byte) ([]byte, error) {
+	value, err := s.db.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return nil, ErrNotFound
+	}
+	return value, err
+}
+
+func (s *LevelDBStore) Delete(key []byte) error {
+	err := s.db.Delete(key, nil)
+	if err == leveldb.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+func (s *LevelDBStore) BatchPut(pairs map[string][]byte) error {
+	batch := new(leveldb.Batch)
+	for key, value := range pairs {
+		batch.Put([]byte(key), value)
+	}
+	return s.db.Write(batch, nil)
+}
+
+func (s *LevelDBStore) Iterate(prefix []byte, fn func(key, value []byte) error) error {
+	iter := s.db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer iter.Release()
+	for iter.Next() {
+		key := append([]byte(nil), iter.Key()...)
+		value := append([]byte(nil), iter.Value()...)
+		if err := fn(key, value); err != nil {
+			return err
+		}
+	}
+	return iter.Error()
+}
+
+func (s *LevelDBStore) Close() error {
+	return s.db.Close()
+}
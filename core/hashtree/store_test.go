@@ -0,0 +1,117 @@
+// MIT License
+//
+// Copyright (c) 2024 sphinx-core
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package hashtree
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func openTestLevelDBStore(t *testing.T) *LevelDBStore {
+	t.Helper()
+	store, err := OpenLevelDBStore(filepath.Join(t.TempDir(), "leaves"))
+	if err != nil {
+		t.Fatalf("OpenLevelDBStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestLevelDBStorePutGet(t *testing.T) {
+	store := openTestLevelDBStore(t)
+
+	if err := store.Put([]byte("k"), []byte("v")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	got, err := store.Get([]byte("k"))
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !bytes.Equal(got, []byte("v")) {
+		t.Fatalf("Get() = %q, want %q", got, "v")
+	}
+}
+
+func TestLevelDBStoreGetMissingKeyReturnsErrNotFound(t *testing.T) {
+	store := openTestLevelDBStore(t)
+
+	if _, err := store.Get([]byte("missing")); err != ErrNotFound {
+		t.Fatalf("Get(missing) error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestSaveLeafAtIndexDoesNotCollide(t *testing.T) {
+	store := openTestLevelDBStore(t)
+
+	if err := SaveLeafAtIndex(store, 0, []byte("sig-0")); err != nil {
+		t.Fatalf("SaveLeafAtIndex(0): %v", err)
+	}
+	if err := SaveLeafAtIndex(store, 1, []byte("sig-1")); err != nil {
+		t.Fatalf("SaveLeafAtIndex(1): %v", err)
+	}
+
+	leaf0, err := FetchLeafFromDB(store, "leaf-0")
+	if err != nil {
+		t.Fatalf("FetchLeafFromDB(leaf-0): %v", err)
+	}
+	leaf1, err := FetchLeafFromDB(store, "leaf-1")
+	if err != nil {
+		t.Fatalf("FetchLeafFromDB(leaf-1): %v", err)
+	}
+
+	if !bytes.Equal(leaf0, []byte("sig-0")) {
+		t.Errorf("leaf-0 = %q, want %q (a later SaveLeafAtIndex must not overwrite it)", leaf0, "sig-0")
+	}
+	if !bytes.Equal(leaf1, []byte("sig-1")) {
+		t.Errorf("leaf-1 = %q, want %q", leaf1, "sig-1")
+	}
+}
+
+func TestLevelDBStoreBatchPutAndIterate(t *testing.T) {
+	store := openTestLevelDBStore(t)
+
+	pairs := map[string][]byte{
+		"leaf-0": []byte("a"),
+		"leaf-1": []byte("b"),
+		"other":  []byte("c"),
+	}
+	if err := store.BatchPut(pairs); err != nil {
+		t.Fatalf("BatchPut: %v", err)
+	}
+
+	seen := map[string][]byte{}
+	err := store.Iterate([]byte("leaf-"), func(key, value []byte) error {
+		seen[string(key)] = value
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Iterate: %v", err)
+	}
+	if len(seen) != 2 {
+		t.Fatalf("Iterate visited %d keys, want 2", len(seen))
+	}
+	if !bytes.Equal(seen["leaf-0"], []byte("a")) || !bytes.Equal(seen["leaf-1"], []byte("b")) {
+		t.Fatalf("Iterate returned unexpected values: %v", seen)
+	}
+}
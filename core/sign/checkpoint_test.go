@@ -0,0 +1,128 @@
+// MIT License
+//
+// Copyright (c) 2024 sphinx-core
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package sign
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/sphinx-core/sphinx-master/core/hashtree"
+)
+
+// memStore is a minimal in-memory hashtree.LeafStore for tests that only
+// exercise checkpoint encode/decode/lookup, not actual signing.
+type memStore struct {
+	data map[string][]byte
+}
+
+func newMemStore() *memStore {
+	return &memStore{data: make(map[string][]byte)}
+}
+
+func (s *memStore) Put(key, value []byte) error {
+	s.data[string(key)] = append([]byte(nil), value...)
+	return nil
+}
+
+func (s *memStore) Get(key []byte) ([]byte, error) {
+	v, ok := s.data[string(key)]
+	if !ok {
+		return nil, hashtree.ErrNotFound
+	}
+	return v, nil
+}
+
+func (s *memStore) Delete(key []byte) error {
+	delete(s.data, string(key))
+	return nil
+}
+
+func (s *memStore) BatchPut(pairs map[string][]byte) error {
+	for k, v := range pairs {
+		s.data[k] = append([]byte(nil), v...)
+	}
+	return nil
+}
+
+func (s *memStore) Iterate(prefix []byte, fn func(key, value []byte) error) error {
+	for k, v := range s.data {
+		if len(k) < len(prefix) || k[:len(prefix)] != string(prefix) {
+			continue
+		}
+		if err := fn([]byte(k), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memStore) Close() error { return nil }
+
+func TestSaveCheckpointRoundTrip(t *testing.T) {
+	store := newMemStore()
+	want := Checkpoint{TreeSize: 4, RootHash: []byte("root"), Timestamp: 1700000000}
+
+	if err := saveCheckpoint(store, want); err != nil {
+		t.Fatalf("saveCheckpoint: %v", err)
+	}
+
+	raw, err := store.Get(checkpointKey(want.TreeSize))
+	if err != nil {
+		t.Fatalf("Get checkpoint key: %v", err)
+	}
+	got := decodeCheckpoint(checkpointKey(want.TreeSize), raw)
+	if got.TreeSize != want.TreeSize || got.Timestamp != want.Timestamp || !bytes.Equal(got.RootHash, want.RootHash) {
+		t.Fatalf("decodeCheckpoint = %+v, want %+v", got, want)
+	}
+}
+
+func TestLastCheckpointReturnsMostRecentBySize(t *testing.T) {
+	store := newMemStore()
+	for _, cp := range []Checkpoint{
+		{TreeSize: 1, RootHash: []byte("r1"), Timestamp: 100},
+		{TreeSize: 10, RootHash: []byte("r10"), Timestamp: 200},
+		{TreeSize: 2, RootHash: []byte("r2"), Timestamp: 150},
+	} {
+		if err := saveCheckpoint(store, cp); err != nil {
+			t.Fatalf("saveCheckpoint(%d): %v", cp.TreeSize, err)
+		}
+	}
+
+	got, err := LastCheckpoint(store)
+	if err != nil {
+		t.Fatalf("LastCheckpoint: %v", err)
+	}
+	if got == nil || got.TreeSize != 10 {
+		t.Fatalf("LastCheckpoint = %+v, want TreeSize 10", got)
+	}
+}
+
+func TestLastCheckpointEmptyStoreReturnsNil(t *testing.T) {
+	got, err := LastCheckpoint(newMemStore())
+	if err != nil {
+		t.Fatalf("LastCheckpoint: %v", err)
+	}
+	if got != nil {
+		t.Fatalf("LastCheckpoint on empty store = %+v, want nil", got)
+	}
+}
@@ -0,0 +1,297 @@
+// MIT License
+//
+// Copyright (c) 2024 sphinx-core
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package sign
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kasperdi/SPHINCSPLUS-golang/parameters"
+	"github.com/kasperdi/SPHINCSPLUS-golang/sphincs"
+	"github.com/sphinx-core/sphinx-master/core/hashtree"
+)
+
+// checkpointPrefix namespaces checkpoint keys in the leaf store. Keys are
+// suffixed with the zero-padded tree size so that iteration in key order
+// also walks checkpoints from oldest to newest.
+const checkpointPrefix = "checkpoint-"
+
+// Checkpoint records the state of the sequencer's Merkle tree at a point
+// in time, analogous to a CT log's tree head. It carries no signature of
+// its own and is persisted unsigned.
+type Checkpoint struct {
+	TreeSize  uint64
+	RootHash  []byte
+	Timestamp int64
+}
+
+// Receipt is returned to a caller of Sequencer.Submit once their message
+// has been signed and sequenced into the tree.
+type Receipt struct {
+	Signature      *sphincs.SPHINCS_SIG
+	LeafIndex      uint64
+	InclusionProof [][]byte
+	Checkpoint     Checkpoint
+}
+
+// SequencerOptions configures batching behavior for a Sequencer.
+type SequencerOptions struct {
+	// ChunkSize is the number of pending submissions that triggers an
+	// immediate flush.
+	ChunkSize int
+	// FlushInterval is the maximum time a submission waits before being
+	// flushed, even if ChunkSize has not been reached.
+	FlushInterval time.Duration
+}
+
+type submission struct {
+	message   []byte
+	receiptCh chan Receipt
+}
+
+// Sequencer batches signing requests the way a CT log sequencer batches
+// certificate submissions: messages accumulate until a chunk size or
+// flush interval is hit, are signed and appended to the Merkle tree as a
+// unit, and a single (unsigned) checkpoint is persisted for the whole batch.
+// This amortizes the cost of growing and checkpointing the tree across
+// many signatures instead of paying it per signature.
+//
+// Signing and appending both go through manager, never directly through
+// sphincs or a Sequencer-owned tree: manager.SignMessage is what binds
+// each signature to the root it was produced against, and manager's
+// CompactTree is the single frontier Sequencer reads sizes and inclusion
+// proofs from. A second, independently-advancing tree on the same store
+// would let the two race and diverge.
+type Sequencer struct {
+	manager *SphincsManager
+	params  *parameters.Parameters
+	sk      *sphincs.SPHINCS_SK
+	store   hashtree.LeafStore
+	opts    SequencerOptions
+
+	mu      sync.Mutex
+	pending []submission
+	flushCh chan struct{}
+	closeCh chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewSequencer creates a Sequencer that signs submitted messages through
+// manager and checkpoints manager's tree to store.
+func NewSequencer(manager *SphincsManager, params *parameters.Parameters, sk *sphincs.SPHINCS_SK, store hashtree.LeafStore, opts SequencerOptions) *Sequencer {
+	return newSequencer(manager, params, sk, store, opts)
+}
+
+// ResumeFrom restores a Sequencer from the last checkpoint persisted in
+// store, rebuilding manager's compact tree state from its own persisted
+// frontier rather than rescanning every past leaf.
+func ResumeFrom(manager *SphincsManager, params *parameters.Parameters, sk *sphincs.SPHINCS_SK, store hashtree.LeafStore, opts SequencerOptions) (*Sequencer, error) {
+	tree, err := hashtree.LoadCompactTree(store)
+	if err != nil {
+		return nil, fmt.Errorf("resuming compact tree: %w", err)
+	}
+	manager = manager.withTree(tree)
+
+	checkpoint, err := LastCheckpoint(store)
+	if err != nil {
+		return nil, fmt.Errorf("loading last checkpoint: %w", err)
+	}
+	if checkpoint != nil && checkpoint.TreeSize != tree.Size() {
+		return nil, fmt.Errorf("last checkpoint tree size %d does not match resumed tree size %d", checkpoint.TreeSize, tree.Size())
+	}
+
+	return newSequencer(manager, params, sk, store, opts), nil
+}
+
+func newSequencer(manager *SphincsManager, params *parameters.Parameters, sk *sphincs.SPHINCS_SK, store hashtree.LeafStore, opts SequencerOptions) *Sequencer {
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = 1
+	}
+	s := &Sequencer{
+		manager: manager,
+		params:  params,
+		sk:      sk,
+		store:   store,
+		opts:    opts,
+		flushCh: make(chan struct{}, 1),
+		closeCh: make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go s.run()
+	return s
+}
+
+// Submit queues message for signing and returns a channel that receives
+// exactly one Receipt once the message has been signed, sequenced into
+// the tree, and checkpointed. If signing, checkpointing or building the
+// inclusion proof fails, the channel is closed without a Receipt.
+func (s *Sequencer) Submit(msg []byte) <-chan Receipt {
+	receiptCh := make(chan Receipt, 1)
+
+	s.mu.Lock()
+	s.pending = append(s.pending, submission{message: msg, receiptCh: receiptCh})
+	shouldFlush := len(s.pending) >= s.opts.ChunkSize
+	s.mu.Unlock()
+
+	if shouldFlush {
+		select {
+		case s.flushCh <- struct{}{}:
+		default:
+		}
+	}
+	return receiptCh
+}
+
+// Close stops the background flush loop, flushing any remaining pending
+// submissions first.
+func (s *Sequencer) Close() {
+	close(s.closeCh)
+	s.wg.Wait()
+}
+
+func (s *Sequencer) run() {
+	defer s.wg.Done()
+
+	// A nil channel blocks forever, so leaving tick unset when no flush
+	// interval is configured effectively disables the timer case below.
+	var tick <-chan time.Time
+	if s.opts.FlushInterval > 0 {
+		ticker := time.NewTicker(s.opts.FlushInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-s.flushCh:
+			s.flush()
+		case <-tick:
+			s.flush()
+		case <-s.closeCh:
+			s.flush()
+			return
+		}
+	}
+}
+
+// flush signs every pending submission, appends each signature as a leaf,
+// persists one checkpoint for the whole batch, and delivers a Receipt to
+// each submitter.
+func (s *Sequencer) flush() {
+	s.mu.Lock()
+	batch := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+
+	if len(batch) == 0 {
+		return
+	}
+
+	type sequenced struct {
+		sub       submission
+		sig       *sphincs.SPHINCS_SIG
+		leafIndex uint64
+	}
+
+	signed := make([]sequenced, 0, len(batch))
+	var root []byte
+	var size uint64
+	for _, sub := range batch {
+		sig, _, err := s.manager.SignMessage(s.params, sub.message, s.sk)
+		if err != nil {
+			close(sub.receiptCh)
+			continue
+		}
+		size, root = s.manager.tree.Size(), s.manager.tree.Root()
+		signed = append(signed, sequenced{sub: sub, sig: sig, leafIndex: size - 1})
+	}
+
+	checkpoint := Checkpoint{TreeSize: size, RootHash: root, Timestamp: time.Now().Unix()}
+	if err := saveCheckpoint(s.store, checkpoint); err != nil {
+		for _, sq := range signed {
+			close(sq.sub.receiptCh)
+		}
+		return
+	}
+
+	for _, sq := range signed {
+		proof, err := s.manager.tree.InclusionProof(sq.leafIndex, size)
+		if err != nil {
+			close(sq.sub.receiptCh)
+			continue
+		}
+		sq.sub.receiptCh <- Receipt{
+			Signature:      sq.sig,
+			LeafIndex:      sq.leafIndex,
+			InclusionProof: proof,
+			Checkpoint:     checkpoint,
+		}
+		close(sq.sub.receiptCh)
+	}
+}
+
+func checkpointKey(treeSize uint64) []byte {
+	return []byte(fmt.Sprintf("%s%020d", checkpointPrefix, treeSize))
+}
+
+func saveCheckpoint(store hashtree.LeafStore, cp Checkpoint) error {
+	buf := make([]byte, 8+8+len(cp.RootHash))
+	binary.BigEndian.PutUint64(buf[0:8], cp.TreeSize)
+	binary.BigEndian.PutUint64(buf[8:16], uint64(cp.Timestamp))
+	copy(buf[16:], cp.RootHash)
+	return store.Put(checkpointKey(cp.TreeSize), buf)
+}
+
+func decodeCheckpoint(key, value []byte) Checkpoint {
+	return Checkpoint{
+		TreeSize:  binary.BigEndian.Uint64(value[0:8]),
+		Timestamp: int64(binary.BigEndian.Uint64(value[8:16])),
+		RootHash:  append([]byte(nil), value[16:]...),
+	}
+}
+
+// LastCheckpoint returns the most recently persisted checkpoint in store,
+// or nil if none has been written yet.
+func LastCheckpoint(store hashtree.LeafStore) (*Checkpoint, error) {
+	var keys [][]byte
+	values := map[string][]byte{}
+	err := store.Iterate([]byte(checkpointPrefix), func(key, value []byte) error {
+		keys = append(keys, key)
+		values[string(key)] = value
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	sort.Slice(keys, func(i, j int) bool { return strings.Compare(string(keys[i]), string(keys[j])) < 0 })
+	last := keys[len(keys)-1]
+	cp := decodeCheckpoint(last, values[string(last)])
+	return &cp, nil
+}
@@ -0,0 +1,161 @@
+// MIT License
+//
+// Copyright (c) 2024 sphinx-core
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package sign
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sync"
+
+	"github.com/kasperdi/SPHINCSPLUS-golang/parameters"
+	"github.com/kasperdi/SPHINCSPLUS-golang/sphincs"
+	"github.com/sphinx-core/sphinx-master/core/hashtree"
+)
+
+// SigningContext tags the digest SignMessage actually signs, separating
+// it from both a raw message hash and the hash tree's own leaf/node
+// domains. Bumping it after a cryptographic review invalidates the old
+// binding scheme without touching the SPHINCS+ parameters themselves.
+const SigningContext = "sphinx-core/sign/v1"
+
+// SphincsManager ties SPHINCS+ key generation, signing and verification to
+// a Merkle leaf store, so that every signature produced is also recorded
+// as a leaf in the hash tree. The root returned alongside a signature is
+// the one it is bound to, taken before its own leaf is appended.
+// Signatures are appended to a hashtree.CompactTree rather than rehashed
+// from scratch each time, so SignMessage stays O(log n) per call instead
+// of rebuilding the whole tree.
+type SphincsManager struct {
+	store hashtree.LeafStore
+	tree  *hashtree.CompactTree
+
+	// mu serializes SignMessage calls. This is separate from tree's own
+	// locking: without it, two concurrent signers could both read the
+	// same pre-append root and bind their signatures to it, even though
+	// only one of them is actually first in the resulting tree.
+	mu sync.Mutex
+}
+
+// NewSphincsManager creates a SphincsManager that records signature leaves
+// in store. store may be any hashtree.LeafStore implementation (LevelDB,
+// BadgerDB, ...).
+func NewSphincsManager(store hashtree.LeafStore) *SphincsManager {
+	return &SphincsManager{store: store, tree: hashtree.NewCompactTree(store)}
+}
+
+// withTree returns a copy of m that reads and appends through tree instead
+// of the one NewSphincsManager built, for callers (Sequencer.ResumeFrom)
+// that need to resume a manager against an already-loaded frontier rather
+// than start it from an empty one.
+func (m *SphincsManager) withTree(tree *hashtree.CompactTree) *SphincsManager {
+	return &SphincsManager{store: m.store, tree: tree}
+}
+
+// GenerateKeys creates a new SPHINCS+ key pair for the given parameter set.
+func (m *SphincsManager) GenerateKeys(params *parameters.Parameters) (*sphincs.SPHINCS_SK, *sphincs.SPHINCS_PK) {
+	return sphincs.Spx_keygen(params)
+}
+
+// SerializeSK serializes a secret key to its byte representation.
+func (m *SphincsManager) SerializeSK(sk *sphincs.SPHINCS_SK) ([]byte, error) {
+	return sk.SerializeSK()
+}
+
+// SerializePK serializes a public key to its byte representation.
+func (m *SphincsManager) SerializePK(pk *sphincs.SPHINCS_PK) ([]byte, error) {
+	return pk.SerializePK()
+}
+
+// SerializeSignature serializes a signature to its byte representation.
+func (m *SphincsManager) SerializeSignature(sig *sphincs.SPHINCS_SIG) ([]byte, error) {
+	return sig.SerializeSignature()
+}
+
+// SignMessage binds the current Merkle root into the message before
+// signing it with sk, then records the resulting signature bytes as a
+// new leaf. Binding the root this way (rather than signing message
+// alone) makes the returned root a real commitment: a verifier who
+// trusts the root also knows it is this exact signature that was
+// produced against it, not just some signature over message.
+//
+// The bound root is necessarily the root *before* this signature's leaf
+// is appended — the new leaf cannot commit to a root that includes
+// itself. SignMessage returns that root; callers needing a proof that
+// this signature was later included in the tree should use the
+// CompactTree/Sequencer APIs, which checkpoint the tree *after* each
+// append.
+func (m *SphincsManager) SignMessage(params *parameters.Parameters, message []byte, sk *sphincs.SPHINCS_SK) (*sphincs.SPHINCS_SIG, *hashtree.HashTreeNode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	root := m.currentRootLocked()
+	digest := boundDigest(root, message)
+	sig := sphincs.Spx_sign(params, digest, sk)
+
+	sigBytes, err := sig.SerializeSignature()
+	if err != nil {
+		return nil, nil, fmt.Errorf("serializing signature: %w", err)
+	}
+	if _, _, err := m.tree.Append(sigBytes); err != nil {
+		return nil, nil, fmt.Errorf("recording signature leaf: %w", err)
+	}
+
+	return sig, root, nil
+}
+
+// currentRootLocked returns the root of every leaf signed so far, or a
+// well-defined empty-tree root if none have been signed yet. Callers
+// must hold m.mu.
+func (m *SphincsManager) currentRootLocked() *hashtree.HashTreeNode {
+	if root := m.tree.Root(); root != nil {
+		return &hashtree.HashTreeNode{Hash: root}
+	}
+	return &hashtree.HashTreeNode{Hash: hashtree.HashLeaf(nil)}
+}
+
+// boundDigest computes H(context || root || message), the value actually
+// signed by SignMessage and recomputed by VerifySignature.
+func boundDigest(root *hashtree.HashTreeNode, message []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte(SigningContext))
+	h.Write(root.Hash)
+	h.Write(message)
+	return h.Sum(nil)
+}
+
+// VerifySignature verifies that sig is a valid SPHINCS+ signature of
+// message as bound to root by SignMessage. root must be the exact root
+// SignMessage returned when producing sig; any other root, including the
+// tree's current root after further signatures, will fail verification.
+func (m *SphincsManager) VerifySignature(params *parameters.Parameters, message []byte, sig *sphincs.SPHINCS_SIG, pk *sphincs.SPHINCS_PK, root *hashtree.HashTreeNode) bool {
+	digest := boundDigest(root, message)
+	return sphincs.Spx_verify(params, digest, sig, pk)
+}
+
+// LegacyVerify verifies signatures produced before root binding was
+// introduced, which were signed over message directly. Use it only to
+// validate pre-migration signatures; new signatures must be checked with
+// VerifySignature.
+func (m *SphincsManager) LegacyVerify(params *parameters.Parameters, message []byte, sig *sphincs.SPHINCS_SIG, pk *sphincs.SPHINCS_PK) bool {
+	return sphincs.Spx_verify(params, message, sig, pk)
+}
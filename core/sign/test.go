@@ -20,9 +20,10 @@ func main() {
 		log.Fatal("Failed to open LevelDB:", err)
 	}
 	defer db.Close()
+	store := hashtree.NewLevelDBStore(db)
 
-	// Initialize the SphincsManager with the LevelDB instance
-	manager := sign.NewSphincsManager(db)
+	// Initialize the SphincsManager with the leaf store
+	manager := sign.NewSphincsManager(store)
 
 	// Generate keys
 	sk, pk := manager.GenerateKeys(params)
@@ -77,13 +78,13 @@ func main() {
 
 	// Save leaves to LevelDB
 	leaves := [][]byte{sigBytes} // Example usage
-	err = hashtree.SaveLeavesToDB(db, leaves)
+	err = hashtree.SaveLeavesToDB(store, leaves)
 	if err != nil {
 		log.Fatal("Failed to save leaves to DB:", err)
 	}
 
 	// Fetch a leaf from LevelDB
-	leaf, err := hashtree.FetchLeafFromDB(db, "leaf-0")
+	leaf, err := hashtree.FetchLeafFromDB(store, "leaf-0")
 	if err != nil {
 		log.Fatal("Failed to fetch leaf from DB:", err)
 	}